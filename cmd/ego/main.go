@@ -0,0 +1,51 @@
+// Command ego dumps and inspects encoded ego object files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tav/ego"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ego dump FILE")
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 || flag.Arg(0) != "dump" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := dump(flag.Arg(1)); err != nil {
+		fmt.Fprintln(os.Stderr, "ego:", err)
+		os.Exit(1)
+	}
+}
+
+// dump reads an object file written by Package.Encode and prints a
+// human-readable summary of its templates and blocks.
+func dump(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p, err := ego.DecodePackage(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("package %s\n", p.Name)
+	for _, t := range p.Templates {
+		fmt.Printf("  template %s (%d blocks)\n", t.Path, len(t.Blocks))
+		for _, b := range t.Blocks {
+			fmt.Printf("    %T\n", b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ego
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newModuleImporter returns a types.Importer rooted at the module
+// containing dir (found by walking up for a go.mod). Imports within that
+// module are resolved by parsing and type-checking the source under the
+// module directory directly; anything else falls back to
+// go/importer.Default(), which is enough for the standard library.
+//
+// go/importer.Default() on its own only finds packages that have already
+// been compiled into a GOPATH workspace, so it can't resolve an import of
+// a sibling package in the same module — exactly the case of a <%! %>
+// parameter type (e.g. *User) declared elsewhere in the project.
+func newModuleImporter(fset *token.FileSet, dir string) types.Importer {
+	modDir, modPath := findModule(dir)
+	if modDir == "" {
+		return importer.Default()
+	}
+	return &moduleImporter{
+		modDir:  modDir,
+		modPath: modPath,
+		fset:    fset,
+		fall:    importer.Default(),
+		cache:   map[string]*types.Package{},
+	}
+}
+
+// moduleImporter is the types.Importer returned by newModuleImporter.
+type moduleImporter struct {
+	modDir  string // directory containing the module's go.mod
+	modPath string // module path declared in go.mod
+	fset    *token.FileSet
+	fall    types.Importer
+	cache   map[string]*types.Package
+}
+
+func (m *moduleImporter) Import(path string) (*types.Package, error) {
+	if path != m.modPath && !strings.HasPrefix(path, m.modPath+"/") {
+		return m.fall.Import(path)
+	}
+	if pkg, ok := m.cache[path]; ok {
+		return pkg, nil
+	}
+
+	dir := m.modDir
+	if rest := strings.TrimPrefix(path, m.modPath); rest != "" {
+		dir = filepath.Join(m.modDir, filepath.FromSlash(strings.TrimPrefix(rest, "/")))
+	}
+
+	files, err := parseDir(m.fset, dir)
+	if err != nil {
+		return nil, fmt.Errorf("ego: import %q: %s", path, err)
+	}
+
+	conf := types.Config{Importer: m}
+	pkg, err := conf.Check(path, m.fset, files, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ego: import %q: %s", path, err)
+	}
+	m.cache[path] = pkg
+	return pkg, nil
+}
+
+// parseDir parses every non-test .go file in dir.
+func parseDir(fset *token.FileSet, dir string) ([]*ast.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return files, nil
+}
+
+// findModule walks up from dir looking for a go.mod, returning its
+// directory and declared module path, or ("", "") if none is found.
+func findModule(dir string) (modDir, modPath string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", ""
+	}
+	for {
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "module ") {
+					return dir, strings.TrimSpace(strings.TrimPrefix(line, "module"))
+				}
+			}
+			return dir, ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,27 @@
+package ego
+
+import (
+	"go/token"
+	"testing"
+)
+
+func TestFindModule(t *testing.T) {
+	dir, path := findModule(".")
+	if dir == "" {
+		t.Fatal("findModule(\".\"): no module found")
+	}
+	if path != "github.com/tav/ego" {
+		t.Errorf("findModule(\".\"): path = %q, want %q", path, "github.com/tav/ego")
+	}
+}
+
+func TestModuleImporterSiblingPackage(t *testing.T) {
+	imp := newModuleImporter(token.NewFileSet(), ".")
+	pkg, err := imp.Import("github.com/tav/ego/testdata/models")
+	if err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+	if pkg.Scope().Lookup("User") == nil {
+		t.Errorf("imported package %s has no User type", pkg.Path())
+	}
+}
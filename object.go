@@ -0,0 +1,420 @@
+package ego
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// objectMagic identifies an encoded ego object file.
+const objectMagic = "EGOB"
+
+// objectVersion is bumped whenever the encoded record layout changes.
+const objectVersion = 4
+
+// Block type tags used in the encoded record stream.
+const (
+	tagDeclarationBlock byte = iota + 1
+	tagTextBlock
+	tagCodeBlock
+	tagHeaderBlock
+	tagPrintBlock
+	tagWriteBlock
+	tagContextBlock
+	tagIncludeBlock
+)
+
+// Encode writes a versioned binary representation of the package to w, so
+// that tools other than the code generator (linters, doc extractors, i18n
+// string extractors) can consume parsed templates without re-parsing the
+// original .ego source. TextBlock.Content is deduplicated into a shared
+// string table, mirroring the table Package.Write already builds for the
+// generated byte-slice literals.
+func (p *Package) Encode(w io.Writer) error {
+	e := &objectEncoder{w: w}
+
+	if _, err := io.WriteString(w, objectMagic); err != nil {
+		return err
+	}
+	if err := e.writeUint32(objectVersion); err != nil {
+		return err
+	}
+	if err := e.writeString(p.Name); err != nil {
+		return err
+	}
+
+	// Build the deduplicated string table up front so block records can
+	// reference entries by index instead of repeating content inline.
+	strs, index := buildStringTable(p)
+	if err := e.writeUint32(uint32(len(strs))); err != nil {
+		return err
+	}
+	for _, s := range strs {
+		if err := e.writeString(s); err != nil {
+			return err
+		}
+	}
+
+	if err := e.writeUint32(uint32(len(p.Templates))); err != nil {
+		return err
+	}
+	for _, t := range p.Templates {
+		if err := e.writeTemplate(t, index); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+// buildStringTable collects every distinct TextBlock.Content across the
+// package and returns it alongside a lookup from content to table index.
+func buildStringTable(p *Package) ([]string, map[string]uint32) {
+	index := map[string]uint32{}
+	var strs []string
+	for _, t := range p.Templates {
+		for _, b := range t.textBlocks() {
+			if _, ok := index[b.Content]; ok {
+				continue
+			}
+			index[b.Content] = uint32(len(strs))
+			strs = append(strs, b.Content)
+		}
+	}
+	return strs, index
+}
+
+func (e *objectEncoder) writeTemplate(t *Template, index map[string]uint32) error {
+	if err := e.writeString(t.Path); err != nil {
+		return err
+	}
+	if err := e.writeUint32(uint32(len(t.Blocks))); err != nil {
+		return err
+	}
+	for _, b := range t.Blocks {
+		if err := e.writeBlock(b, index); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+func (e *objectEncoder) writeBlock(b Block, index map[string]uint32) error {
+	switch b := b.(type) {
+	case *DeclarationBlock:
+		e.writeTag(tagDeclarationBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Content)
+		e.writeString(b.Name)
+		e.writeBool(b.Receiver != nil)
+		if b.Receiver != nil {
+			e.writeParam(*b.Receiver)
+		}
+		e.writeUint32(uint32(len(b.Params)))
+		for _, p := range b.Params {
+			e.writeParam(p)
+		}
+		e.writeString(b.Ctx)
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	case *TextBlock:
+		e.writeTag(tagTextBlock)
+		e.writePos(b.Pos)
+		e.writeUint32(index[b.Content])
+	case *CodeBlock:
+		e.writeTag(tagCodeBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Content)
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	case *HeaderBlock:
+		e.writeTag(tagHeaderBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Content)
+	case *PrintBlock:
+		e.writeTag(tagPrintBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Content)
+		e.writeUint32(uint32(b.Context))
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	case *WriteBlock:
+		e.writeTag(tagWriteBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Content)
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	case *ContextBlock:
+		e.writeTag(tagContextBlock)
+		e.writePos(b.Pos)
+		e.writeUint32(uint32(b.Context))
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	case *IncludeBlock:
+		e.writeTag(tagIncludeBlock)
+		e.writePos(b.Pos)
+		e.writeString(b.Path)
+		e.writeString(b.Args)
+		e.writeBool(b.TrimLeft)
+		e.writeBool(b.TrimRight)
+	default:
+		return fmt.Errorf("ego: cannot encode block of type %T", b)
+	}
+	return e.err
+}
+
+// objectEncoder writes object file records, latching the first error so
+// callers can chain writes without checking every call individually.
+type objectEncoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *objectEncoder) writeUint32(v uint32) error {
+	if e.err != nil {
+		return e.err
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	_, e.err = e.w.Write(b[:])
+	return e.err
+}
+
+func (e *objectEncoder) writeString(s string) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.writeUint32(uint32(len(s))) != nil {
+		return e.err
+	}
+	_, e.err = io.WriteString(e.w, s)
+	return e.err
+}
+
+func (e *objectEncoder) writeTag(tag byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write([]byte{tag})
+}
+
+func (e *objectEncoder) writePos(p Pos) {
+	e.writeString(p.Path)
+	e.writeUint32(uint32(p.LineNo))
+}
+
+func (e *objectEncoder) writeBool(v bool) {
+	if e.err != nil {
+		return
+	}
+	var b [1]byte
+	if v {
+		b[0] = 1
+	}
+	_, e.err = e.w.Write(b[:])
+}
+
+func (e *objectEncoder) writeParam(p Param) {
+	e.writeString(p.Name)
+	e.writeString(p.Type)
+}
+
+// DecodePackage reads a package previously written by Package.Encode.
+func DecodePackage(r io.Reader) (*Package, error) {
+	d := &objectDecoder{r: r}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("ego: read magic: %s", err)
+	}
+	if string(magic[:]) != objectMagic {
+		return nil, fmt.Errorf("ego: not an ego object file")
+	}
+
+	version := d.readUint32()
+	if d.err == nil && version != objectVersion {
+		return nil, fmt.Errorf("ego: unsupported object file version %d", version)
+	}
+
+	p := &Package{Name: d.readString()}
+
+	strs := make([]string, d.readCount())
+	for i := range strs {
+		strs[i] = d.readString()
+	}
+
+	p.Templates = make([]*Template, d.readCount())
+	for i := range p.Templates {
+		t, err := d.readTemplate(strs)
+		if err != nil {
+			return nil, err
+		}
+		p.Templates[i] = t
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := p.resolveIncludes(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d *objectDecoder) readTemplate(strs []string) (*Template, error) {
+	t := &Template{Path: d.readString()}
+	t.Blocks = make([]Block, d.readCount())
+	for i := range t.Blocks {
+		b, err := d.readBlock(strs)
+		if err != nil {
+			return nil, err
+		}
+		t.Blocks[i] = b
+	}
+	return t, d.err
+}
+
+func (d *objectDecoder) readBlock(strs []string) (Block, error) {
+	tag := d.readTag()
+	pos := d.readPos()
+	switch tag {
+	case tagDeclarationBlock:
+		content := d.readString()
+		name := d.readString()
+		var recv *Param
+		if d.readBool() {
+			p := d.readParam()
+			recv = &p
+		}
+		params := make([]Param, d.readCount())
+		for i := range params {
+			params[i] = d.readParam()
+		}
+		ctx := d.readString()
+		return &DeclarationBlock{
+			Pos: pos, Content: content, Name: name,
+			Receiver: recv, Params: params, Ctx: ctx,
+			TrimLeft: d.readBool(), TrimRight: d.readBool(),
+		}, d.err
+	case tagTextBlock:
+		return &TextBlock{Pos: pos, Content: d.readStringRef(strs)}, d.err
+	case tagCodeBlock:
+		content := d.readString()
+		return &CodeBlock{Pos: pos, Content: content, TrimLeft: d.readBool(), TrimRight: d.readBool()}, d.err
+	case tagHeaderBlock:
+		return &HeaderBlock{Pos: pos, Content: d.readString()}, d.err
+	case tagPrintBlock:
+		content := d.readString()
+		ctx := Context(d.readUint32())
+		return &PrintBlock{Pos: pos, Content: content, Context: ctx, TrimLeft: d.readBool(), TrimRight: d.readBool()}, d.err
+	case tagWriteBlock:
+		content := d.readString()
+		return &WriteBlock{Pos: pos, Content: content, TrimLeft: d.readBool(), TrimRight: d.readBool()}, d.err
+	case tagContextBlock:
+		ctx := Context(d.readUint32())
+		return &ContextBlock{Pos: pos, Context: ctx, TrimLeft: d.readBool(), TrimRight: d.readBool()}, d.err
+	case tagIncludeBlock:
+		path := d.readString()
+		args := d.readString()
+		return &IncludeBlock{Pos: pos, Path: path, Args: args, TrimLeft: d.readBool(), TrimRight: d.readBool()}, d.err
+	default:
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, fmt.Errorf("ego: unknown block tag %d", tag)
+	}
+}
+
+// objectDecoder reads object file records, latching the first error so
+// callers can chain reads without checking every call individually.
+type objectDecoder struct {
+	r   io.Reader
+	err error
+}
+
+// maxDecodeCount bounds slice and string lengths read from an object file,
+// so that a truncated or corrupted file fails with a decode error instead
+// of an out-of-range panic or an attempt to allocate an enormous slice.
+const maxDecodeCount = 1 << 24
+
+func (d *objectDecoder) readUint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	var b [4]byte
+	if _, d.err = io.ReadFull(d.r, b[:]); d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// readCount reads a length-prefixed count and rejects anything implausibly
+// large before the caller uses it to size a make(), so a corrupt count
+// field can't force a huge allocation.
+func (d *objectDecoder) readCount() uint32 {
+	n := d.readUint32()
+	if d.err != nil {
+		return 0
+	}
+	if n > maxDecodeCount {
+		d.err = fmt.Errorf("ego: corrupt object file: count %d exceeds maximum %d", n, maxDecodeCount)
+		return 0
+	}
+	return n
+}
+
+func (d *objectDecoder) readString() string {
+	n := d.readCount()
+	if d.err != nil || n == 0 {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, d.err = io.ReadFull(d.r, b); d.err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// readStringRef reads a string table index and returns the referenced
+// entry, failing with a decode error rather than panicking if the index
+// is out of range for strs (e.g. from a truncated or hand-edited file).
+func (d *objectDecoder) readStringRef(strs []string) string {
+	i := d.readUint32()
+	if d.err != nil {
+		return ""
+	}
+	if int(i) >= len(strs) {
+		d.err = fmt.Errorf("ego: corrupt object file: string table index %d out of range (table has %d entries)", i, len(strs))
+		return ""
+	}
+	return strs[i]
+}
+
+func (d *objectDecoder) readBool() bool {
+	if d.err != nil {
+		return false
+	}
+	var b [1]byte
+	if _, d.err = io.ReadFull(d.r, b[:]); d.err != nil {
+		return false
+	}
+	return b[0] != 0
+}
+
+func (d *objectDecoder) readParam() Param {
+	return Param{Name: d.readString(), Type: d.readString()}
+}
+
+func (d *objectDecoder) readTag() byte {
+	if d.err != nil {
+		return 0
+	}
+	var b [1]byte
+	if _, d.err = io.ReadFull(d.r, b[:]); d.err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *objectDecoder) readPos() Pos {
+	path := d.readString()
+	return Pos{Path: path, LineNo: int(d.readUint32())}
+}
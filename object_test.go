@@ -0,0 +1,180 @@
+package ego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDeclarationBlockRoundTrip(t *testing.T) {
+	p := &Package{
+		Name: "p",
+		Templates: []*Template{
+			{
+				Path: "a.ego",
+				Blocks: []Block{
+					&DeclarationBlock{
+						Pos:      Pos{Path: "a.ego", LineNo: 1},
+						Content:  "func (h *Handler) Render(ctx context.Context, user *User)",
+						Name:     "Render",
+						Receiver: &Param{Name: "h", Type: "*Handler"},
+						Params: []Param{
+							{Name: "ctx", Type: "context.Context"},
+							{Name: "user", Type: "*User"},
+						},
+						Ctx: "ctx",
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	got, err := DecodePackage(&buf)
+	if err != nil {
+		t.Fatalf("DecodePackage: %s", err)
+	}
+
+	decl, ok := got.Templates[0].Blocks[0].(*DeclarationBlock)
+	if !ok {
+		t.Fatalf("Blocks[0] = %T, want *DeclarationBlock", got.Templates[0].Blocks[0])
+	}
+	want := p.Templates[0].Blocks[0].(*DeclarationBlock)
+	if decl.Name != want.Name {
+		t.Errorf("Name = %q, want %q", decl.Name, want.Name)
+	}
+	if decl.Ctx != want.Ctx {
+		t.Errorf("Ctx = %q, want %q", decl.Ctx, want.Ctx)
+	}
+	if decl.Receiver == nil || *decl.Receiver != *want.Receiver {
+		t.Errorf("Receiver = %v, want %v", decl.Receiver, want.Receiver)
+	}
+	if len(decl.Params) != len(want.Params) {
+		t.Fatalf("Params = %v, want %v", decl.Params, want.Params)
+	}
+	for i, param := range want.Params {
+		if decl.Params[i] != param {
+			t.Errorf("Params[%d] = %v, want %v", i, decl.Params[i], param)
+		}
+	}
+}
+
+func TestIncludeBlockRoundTrip(t *testing.T) {
+	p := &Package{
+		Name: "p",
+		Templates: []*Template{
+			{
+				Path: "a.ego",
+				Blocks: []Block{
+					&DeclarationBlock{Content: "func Render()", Name: "Render"},
+				},
+			},
+			{
+				Path: "b.ego",
+				Blocks: []Block{
+					&DeclarationBlock{Content: "func RenderB()", Name: "RenderB"},
+					&IncludeBlock{Pos: Pos{Path: "b.ego", LineNo: 2}, Path: "a.ego"},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	got, err := DecodePackage(&buf)
+	if err != nil {
+		t.Fatalf("DecodePackage: %s", err)
+	}
+
+	inc, ok := got.Templates[1].Blocks[1].(*IncludeBlock)
+	if !ok {
+		t.Fatalf("Blocks[1] = %T, want *IncludeBlock", got.Templates[1].Blocks[1])
+	}
+	if inc.Path != "a.ego" {
+		t.Errorf("Path = %q, want %q", inc.Path, "a.ego")
+	}
+	if inc.Callee == nil || inc.Callee.Name != "Render" {
+		t.Errorf("Callee = %v, want the decoded a.ego DeclarationBlock", inc.Callee)
+	}
+}
+
+// TestDecodePackageCorruptStringIndex reproduces a handcrafted object file
+// with a 0-entry string table and a TextBlock record that references string
+// table index 5. Before the decoder bounds-checked table indices, this
+// panicked with an out-of-range index instead of returning an error; a
+// corrupt or truncated object file must not be able to crash a consumer.
+func TestDecodePackageCorruptStringIndex(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(objectMagic)
+	writeU32 := func(v uint32) {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], v)
+		buf.Write(b[:])
+	}
+	writeStr := func(s string) {
+		writeU32(uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	writeU32(objectVersion)
+	writeStr("p")     // package name
+	writeU32(0)       // string table: 0 entries
+	writeU32(1)       // 1 template
+	writeStr("a.ego") // template path
+	writeU32(1)       // 1 block
+	buf.WriteByte(tagTextBlock)
+	writeStr("a.ego") // block pos path
+	writeU32(1)       // block pos line
+	writeU32(5)       // string table index 5, out of range for a 0-entry table
+
+	if _, err := DecodePackage(&buf); err == nil {
+		t.Fatal("DecodePackage with an out-of-range string table index: want error, got nil")
+	}
+}
+
+func TestTrimMarkerRoundTrip(t *testing.T) {
+	p := &Package{
+		Name: "p",
+		Templates: []*Template{
+			{
+				Path: "a.ego",
+				Blocks: []Block{
+					&CodeBlock{Content: "for range xs {", TrimLeft: true, TrimRight: true},
+					&PrintBlock{Content: "x", TrimRight: true},
+					&WriteBlock{Content: "b", TrimLeft: true},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+
+	got, err := DecodePackage(&buf)
+	if err != nil {
+		t.Fatalf("DecodePackage: %s", err)
+	}
+
+	blocks := got.Templates[0].Blocks
+	code := blocks[0].(*CodeBlock)
+	if !code.TrimLeft || !code.TrimRight {
+		t.Errorf("CodeBlock trim markers = (%v, %v), want (true, true)", code.TrimLeft, code.TrimRight)
+	}
+	print := blocks[1].(*PrintBlock)
+	if print.TrimLeft || !print.TrimRight {
+		t.Errorf("PrintBlock trim markers = (%v, %v), want (false, true)", print.TrimLeft, print.TrimRight)
+	}
+	write := blocks[2].(*WriteBlock)
+	if !write.TrimLeft || write.TrimRight {
+		t.Errorf("WriteBlock trim markers = (%v, %v), want (true, false)", write.TrimLeft, write.TrimRight)
+	}
+}
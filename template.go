@@ -7,6 +7,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
 	"os"
 	"strconv"
@@ -34,8 +35,26 @@ func (t *Template) Write(w io.Writer) error {
 	// Write function declaration.
 	decl.write(buf)
 
-	// Write non-header blocks.
+	// Write non-header blocks, tracking the current escaping context as we
+	// go so each PrintBlock picks up the context of the surrounding
+	// <%html %> / <%attr %> / <%url %> / <%js %> / <%css %> directive. An
+	// IncludeBlock calls into another template's own context, so it can
+	// only be emitted where the two agree on what that context is;
+	// otherwise the callee's print sites would escape for the wrong
+	// context with no indication at the call site.
+	ctx := ContextText
 	for _, b := range t.nonHeaderBlocks() {
+		switch b := b.(type) {
+		case *ContextBlock:
+			ctx = b.Context
+			continue
+		case *PrintBlock:
+			b.Context = ctx
+		case *IncludeBlock:
+			if b.Callee != nil && b.Callee.EntryContext != ctx {
+				return fmt.Errorf("ego: %s: include of %q under %s context, but callee assumes %s context", t.Path, b.Path, ctx, b.Callee.EntryContext)
+			}
+		}
 		if err := b.write(buf); err != nil {
 			return err
 		}
@@ -80,6 +99,30 @@ func (t *Template) nonHeaderBlocks() []Block {
 	return blocks
 }
 
+// startContext reports the escaping context in effect at the start of the
+// template's body, as determined by any <%html %> / <%attr %> / <%url %>
+// / <%js %> / <%css %> directives immediately preceding its first block of
+// actual content. A template with no such leading directive starts in
+// ContextText, matching the default Template.Write begins with. Blank
+// TextBlocks (e.g. the newline between a <%! %> declaration and the
+// directive that follows it) are skipped rather than treated as content,
+// since Package.Write trims them to nothing before Template.Write emits
+// them.
+func (t *Template) startContext() Context {
+	ctx := ContextText
+	for _, b := range t.nonHeaderBlocks() {
+		if cb, ok := b.(*ContextBlock); ok {
+			ctx = cb.Context
+			continue
+		}
+		if tb, ok := b.(*TextBlock); ok && strings.TrimSpace(tb.Content) == "" {
+			continue
+		}
+		break
+	}
+	return ctx
+}
+
 func (t *Template) textBlocks() []*TextBlock {
 	var blocks []*TextBlock
 	for _, b := range t.Blocks {
@@ -90,7 +133,10 @@ func (t *Template) textBlocks() []*TextBlock {
 	return blocks
 }
 
-// normalize joins together adjacent text blocks.
+// normalize joins together adjacent text blocks, applies trim markers
+// (<%- / -%>) to the text blocks surrounding them, marks text that
+// provably can't be reached at runtime as dead, and drops any text block
+// that ends up empty.
 func (t *Template) normalize() {
 	var a []Block
 	for _, b := range t.Blocks {
@@ -101,6 +147,105 @@ func (t *Template) normalize() {
 		}
 	}
 	t.Blocks = a
+
+	t.trim()
+	t.eliminateDeadText()
+
+	a = a[:0]
+	for _, b := range t.Blocks {
+		if b, ok := b.(*TextBlock); ok && b.Content == "" {
+			continue
+		}
+		a = append(a, b)
+	}
+	t.Blocks = a
+}
+
+// trim applies the trim markers on each block's delimiters (<%- strips
+// trailing whitespace from the preceding text, -%> strips leading
+// whitespace from the following text) to the surrounding TextBlocks.
+func (t *Template) trim() {
+	for i, b := range t.Blocks {
+		left, right := trimMarkers(b)
+		if left && i > 0 {
+			if prev, ok := t.Blocks[i-1].(*TextBlock); ok {
+				prev.Content = strings.TrimRight(prev.Content, " \t\r\n")
+			}
+		}
+		if right && i+1 < len(t.Blocks) {
+			if next, ok := t.Blocks[i+1].(*TextBlock); ok {
+				next.Content = strings.TrimLeft(next.Content, " \t\r\n")
+			}
+		}
+	}
+}
+
+// trimMarkers reports the trim flags set on a block's delimiters.
+func trimMarkers(b Block) (left, right bool) {
+	switch b := b.(type) {
+	case *CodeBlock:
+		return b.TrimLeft, b.TrimRight
+	case *PrintBlock:
+		return b.TrimLeft, b.TrimRight
+	case *WriteBlock:
+		return b.TrimLeft, b.TrimRight
+	case *ContextBlock:
+		return b.TrimLeft, b.TrimRight
+	case *IncludeBlock:
+		return b.TrimLeft, b.TrimRight
+	case *DeclarationBlock:
+		return b.TrimLeft, b.TrimRight
+	default:
+		return false, false
+	}
+}
+
+// eliminateDeadText marks TextBlocks that sit immediately after a
+// CodeBlock ending in an unconditional `return` as dead, so Package.Write
+// never emits a c.Write call for them (and never adds them to the
+// deduped byte-slice table). This is deliberately conservative: it only
+// looks at the statement immediately preceding the text, so it catches
+// the common "early return" case without attempting full control-flow
+// analysis of the generated function.
+//
+// Known gap: a TextBlock made unreachable by a trivially-false loop
+// condition (e.g. sitting after a `for false {` that never runs its
+// body) is not detected. Proving that requires matching the CodeBlock
+// that opens the loop against the one that closes it and evaluating the
+// condition between them, rather than the single preceding-statement
+// check done here; until that's worth the complexity, such text is
+// still emitted.
+func (t *Template) eliminateDeadText() {
+	afterReturn := false
+	for _, b := range t.Blocks {
+		switch b := b.(type) {
+		case *CodeBlock:
+			afterReturn = b.endsWithReturn()
+		case *TextBlock:
+			if afterReturn {
+				b.Dead = true
+			}
+		default:
+			afterReturn = false
+		}
+	}
+}
+
+// endsWithReturn reports whether the code block's last top-level
+// statement is a return, as determined by parsing it as a function body
+// via go/parser.
+func (b *CodeBlock) endsWithReturn() bool {
+	src := "package p\nfunc _() {\n" + b.Content + "\n}\n"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return false
+	}
+	decl, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok || decl.Body == nil || len(decl.Body.List) == 0 {
+		return false
+	}
+	_, ok = decl.Body.List[len(decl.Body.List)-1].(*ast.ReturnStmt)
+	return ok
 }
 
 // Block represents an element of the template.
@@ -115,32 +260,129 @@ func (b *CodeBlock) block()        {}
 func (b *HeaderBlock) block()      {}
 func (b *PrintBlock) block()       {}
 func (b *WriteBlock) block()       {}
-
-// DeclarationBlock represents a block that declaration the function signature.
+func (b *ContextBlock) block()     {}
+func (b *IncludeBlock) block()     {}
+
+// DeclarationBlock represents a block that declares the function signature.
+// Content holds the raw signature text as written in the <%! %> directive
+// (e.g. "func Render(w io.Writer, data *Data) error"); Name, Receiver,
+// Params, and Ctx hold the same signature parsed into structured form so
+// other templates can emit direct calls to this one (see IncludeBlock)
+// and tooling can read it through Package.Manifest. Result types are not
+// captured in the structured fields, and Template.Write does not
+// regenerate the function head from them: it writes Content verbatim.
+// EntryContext is filled in
+// by Package.Manifest from the template's own leading <%html %> / <%js %>
+// / ... directive (ContextText if it has none), so an include from
+// another template can be checked against the context its callee
+// actually assumes.
 type DeclarationBlock struct {
-	Pos     Pos
-	Content string
+	Pos          Pos
+	Content      string
+	Name         string
+	Receiver     *Param
+	Params       []Param
+	Ctx          string
+	EntryContext Context
+	TrimLeft     bool
+	TrimRight    bool
+}
+
+// Param represents a single parameter (or receiver) in a template's
+// declared signature.
+type Param struct {
+	Name string
+	Type string
 }
 
 func (b *DeclarationBlock) write(buf *bytes.Buffer) error {
+	b.Pos.write(buf)
 	fmt.Fprintf(buf, "%s {\n", b.Content)
 	return nil
 }
 
+// parseDeclaration parses a <%! %> signature such as
+// "func Name(ctx context.Context, user *User)" into a DeclarationBlock,
+// by parsing it as a function declaration with go/parser.ParseFile and
+// reading the receiver and parameter lists off the result. Content
+// is preserved verbatim so write() keeps working even if the signature
+// uses syntax the structured fields don't capture.
+func parseDeclaration(pos Pos, content string) (*DeclarationBlock, error) {
+	b := &DeclarationBlock{Pos: pos, Content: content}
+
+	src := "package p\n" + content + " {}\n"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ego: parse declaration: %s", err)
+	}
+	if len(f.Decls) != 1 {
+		return nil, fmt.Errorf("ego: parse declaration: expected a single func declaration")
+	}
+	decl, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok {
+		return nil, fmt.Errorf("ego: parse declaration: expected a func declaration")
+	}
+
+	b.Name = decl.Name.Name
+	if decl.Recv != nil && len(decl.Recv.List) == 1 {
+		p := fieldToParam(decl.Recv.List[0])
+		b.Receiver = &p
+	}
+	for _, field := range decl.Type.Params.List {
+		for _, p := range fieldToParams(field) {
+			if p.Type == "context.Context" && b.Ctx == "" {
+				b.Ctx = p.Name
+			}
+			b.Params = append(b.Params, p)
+		}
+	}
+	return b, nil
+}
+
+// fieldToParam converts a single-name *ast.Field (as found in a receiver
+// list, which allows at most one name) to a Param.
+func fieldToParam(field *ast.Field) Param {
+	params := fieldToParams(field)
+	if len(params) == 0 {
+		return Param{Type: types.ExprString(field.Type)}
+	}
+	return params[0]
+}
+
+// fieldToParams converts an *ast.Field from a parameter list to one Param
+// per name (Go allows grouping, e.g. "a, b int"), rendering the type with
+// go/types so the result matches how it would be printed back out.
+func fieldToParams(field *ast.Field) []Param {
+	typ := types.ExprString(field.Type)
+	if len(field.Names) == 0 {
+		return []Param{{Type: typ}}
+	}
+	params := make([]Param, len(field.Names))
+	for i, n := range field.Names {
+		params[i] = Param{Name: n.Name, Type: typ}
+	}
+	return params
+}
+
 // TextBlock represents a UTF-8 encoded block of text that is written to the writer as-is.
+// Dead is set by Template.eliminateDeadText when the text has been proven
+// unreachable; Package.Write skips it entirely rather than emitting a
+// c.Write call for it.
 type TextBlock struct {
 	Pos     Pos
 	Content string
 	ID      int
+	Dead    bool
 }
 
 func (b *TextBlock) write(buf *bytes.Buffer) error {
-	if b.Content == "" {
+	if b.Content == "" || b.Dead {
 		return nil
 	}
 	text := strconv.QuoteToASCII(b.Content)
 	text = strings.Replace(text[1:len(text)-1], `\n`, "\n\t// ", -1)
 	fmt.Fprintf(buf, "// %s\n", text)
+	b.Pos.write(buf)
 	fmt.Fprintf(buf, "c.Write(__%d)\n", b.ID)
 	return nil
 }
@@ -153,11 +395,14 @@ func isTextBlock(b Block) bool {
 
 // CodeBlock represents a Go code block that is printed as-is to the template.
 type CodeBlock struct {
-	Pos     Pos
-	Content string
+	Pos       Pos
+	Content   string
+	TrimLeft  bool
+	TrimRight bool
 }
 
 func (b *CodeBlock) write(buf *bytes.Buffer) error {
+	b.Pos.write(buf)
 	fmt.Fprintln(buf, b.Content)
 	return nil
 }
@@ -174,26 +419,172 @@ func (b *HeaderBlock) write(buf *bytes.Buffer) error {
 }
 
 // PrintBlock represents a block of the template that is printed out to the writer.
+// Context determines which escaper is applied to Content before it is written.
 type PrintBlock struct {
-	Pos     Pos
-	Content string
+	Pos       Pos
+	Content   string
+	Context   Context
+	TrimLeft  bool
+	TrimRight bool
 }
 
 func (b *PrintBlock) write(buf *bytes.Buffer) error {
-	fmt.Fprintf(buf, "c.Write(Escape(%s))\n", b.Content)
+	b.Pos.write(buf)
+	fmt.Fprintf(buf, "c.Write(%s(%s))\n", b.Context.escaper(), b.Content)
 	return nil
 }
 
+// Context represents the lexical context a PrintBlock is printed in, as
+// determined by walking the surrounding <%html %>, <%attr %>, <%url %>,
+// <%js %>, and <%css %> directives. The generator uses it to pick the
+// escaper for each print site, rather than applying a single global
+// Escape() regardless of where the value ends up in the output.
+type Context int
+
+const (
+	// ContextText is the default context and uses the global Escape().
+	ContextText Context = iota
+	ContextHTML
+	ContextHTMLAttr
+	ContextURL
+	ContextJS
+	ContextCSS
+)
+
+// escaper returns the name of the escaper function to call for the context.
+func (c Context) escaper() string {
+	switch c {
+	case ContextHTML:
+		return "EscapeHTML"
+	case ContextHTMLAttr:
+		return "EscapeHTMLAttr"
+	case ContextURL:
+		return "EscapeURL"
+	case ContextJS:
+		return "EscapeJS"
+	case ContextCSS:
+		return "EscapeCSS"
+	default:
+		return "Escape"
+	}
+}
+
+// String returns the directive name associated with the context (e.g.
+// "html" for ContextHTML), for use in error messages.
+func (c Context) String() string {
+	switch c {
+	case ContextHTML:
+		return "html"
+	case ContextHTMLAttr:
+		return "attr"
+	case ContextURL:
+		return "url"
+	case ContextJS:
+		return "js"
+	case ContextCSS:
+		return "css"
+	default:
+		return "text"
+	}
+}
+
 type WriteBlock struct {
-	Pos     Pos
-	Content string
+	Pos       Pos
+	Content   string
+	TrimLeft  bool
+	TrimRight bool
 }
 
 func (b *WriteBlock) write(buf *bytes.Buffer) error {
+	b.Pos.write(buf)
 	fmt.Fprintf(buf, "c.Write(%s)\n", b.Content)
 	return nil
 }
 
+// ContextBlock marks a switch in the surrounding escaping context, emitted
+// by the lexer for <%html %>, <%attr %>, <%url %>, <%js %>, and <%css %>
+// directives. It carries no output of its own; Template.Write consumes it
+// to set the Context on the PrintBlocks that follow.
+type ContextBlock struct {
+	Pos       Pos
+	Context   Context
+	TrimLeft  bool
+	TrimRight bool
+}
+
+func (b *ContextBlock) write(buf *bytes.Buffer) error {
+	return nil
+}
+
+// IncludeBlock represents a <%~ "path/to/other.ego" arg1, arg2 %> directive,
+// which calls another template in the same package as a subroutine. Path
+// is resolved against the callee's Template.Path and Args is the raw,
+// comma-separated argument-list text from the call site. Callee is filled
+// in by Package.resolveIncludes once every template's DeclarationBlock has
+// been parsed, so the call can be emitted directly rather than looked up
+// at runtime.
+type IncludeBlock struct {
+	Pos       Pos
+	Path      string
+	Args      string
+	Callee    *DeclarationBlock
+	TrimLeft  bool
+	TrimRight bool
+}
+
+func (b *IncludeBlock) write(buf *bytes.Buffer) error {
+	if b.Callee == nil {
+		return fmt.Errorf("ego: %s: unresolved include of %q", b.Pos.Path, b.Path)
+	}
+	b.Pos.write(buf)
+	args := "c"
+	if b.Args != "" {
+		args += ", " + b.Args
+	}
+	fmt.Fprintf(buf, "%s(%s)\n", b.Callee.Name, args)
+	return nil
+}
+
+// bind resolves the include against the callee's parsed signature,
+// checking that the number of arguments at the call site matches the
+// number of declared parameters (the leading writer parameter, "c", is
+// supplied implicitly and excluded from the count).
+func (b *IncludeBlock) bind(callee *DeclarationBlock) error {
+	want := len(callee.Params) - 1
+	if want < 0 {
+		want = 0
+	}
+	got, err := countArgs(b.Args)
+	if err != nil {
+		return fmt.Errorf("ego: include of %q: %s", b.Path, err)
+	}
+	if got != want {
+		return fmt.Errorf("ego: include of %q: got %d argument(s), want %d", b.Path, got, want)
+	}
+	b.Callee = callee
+	return nil
+}
+
+// countArgs parses args as the argument list of a call expression (as if
+// written "f(args)") and returns how many arguments it has, using
+// go/parser rather than splitting on "," so that a comma inside a
+// composite literal, nested call, or map/slice literal in one of the
+// arguments isn't mistaken for an argument separator.
+func countArgs(args string) (int, error) {
+	if strings.TrimSpace(args) == "" {
+		return 0, nil
+	}
+	expr, err := parser.ParseExpr("f(" + args + ")")
+	if err != nil {
+		return 0, fmt.Errorf("parse arguments: %s", err)
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return 0, fmt.Errorf("parse arguments: expected a call expression")
+	}
+	return len(call.Args), nil
+}
+
 // Pos represents a position in a given file.
 type Pos struct {
 	Path   string
@@ -212,15 +603,33 @@ type Package struct {
 	Templates []*Template
 }
 
-// Write writes out the package header and templates to a writer.
+// Write writes out the package header and templates to a writer. Before
+// anything reaches w, the assembled source is type-checked with go/types
+// so that undeclared identifiers and other errors in <%= %> / <% %> blocks
+// are caught at generation time rather than at `go build` time.
 func (p *Package) Write(w io.Writer) error {
-	if err := p.writeHeader(w); err != nil {
+	var buf bytes.Buffer
+
+	if err := p.writeHeader(&buf); err != nil {
+		return err
+	}
+	for _, t := range p.Templates {
+		t.normalize()
+	}
+	// resolveIncludes runs after normalize, so it reads each callee's
+	// entry context off its leading blocks as they stand after normalize
+	// has merged adjacent TextBlocks, applied trim markers and dropped the
+	// TextBlocks left empty.
+	if err := p.resolveIncludes(); err != nil {
 		return err
 	}
 	id := 0
 	texts := map[string]int{}
 	for _, t := range p.Templates {
 		for _, b := range t.textBlocks() {
+			if b.Dead {
+				continue
+			}
 			b.Content = strings.TrimRight(strings.TrimLeft(b.Content, "\n "), "\n")
 			if b.Content == "" {
 				continue
@@ -229,26 +638,102 @@ func (p *Package) Write(w io.Writer) error {
 				b.ID = curID
 			} else {
 				if id == 0 {
-					fmt.Fprintf(w, "var (\n")
+					fmt.Fprintf(&buf, "var (\n")
 				}
 				id++
 				b.ID = id
 				texts[b.Content] = id
-				fmt.Fprintf(w, "\t__%d = []byte(%q)\n", id, b.Content)
+				fmt.Fprintf(&buf, "\t__%d = []byte(%q)\n", id, b.Content)
 			}
 		}
 	}
 	if id != 0 {
-		fmt.Fprint(w, ")\n\n")
+		fmt.Fprint(&buf, ")\n\n")
 	}
 	for _, t := range p.Templates {
-		if err := t.Write(w); err != nil {
+		if err := t.Write(&buf); err != nil {
 			return fmt.Errorf("template: %s: err", t.Path)
 		}
 	}
+
+	if err := p.verify(buf.Bytes()); err != nil {
+		return err
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// verify type-checks the generated source with go/types. Because the
+// declaration, text, code, print, write and include blocks write a //line
+// directive ahead of their generated code (see Pos.write), the positions
+// on errors in the function bodies already point back through the
+// token.FileSet to the original .ego source; header blocks write none, so
+// errors in them are reported against the generated file. Imports are
+// resolved with newModuleImporter, rooted at the current directory, so
+// that a template parameter type declared in a sibling package of the
+// same module resolves the same way `go build` would resolve it.
+func (p *Package) verify(src []byte) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "ego.go", src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("ego: parse generated code: %s", err)
+	}
+
+	var errs []string
+	conf := types.Config{
+		Importer: newModuleImporter(fset, "."),
+		Error: func(err error) {
+			errs = append(errs, err.Error())
+		},
+	}
+	conf.Check(p.Name, fset, []*ast.File{f}, nil)
+	if len(errs) > 0 {
+		return fmt.Errorf("ego: type-checking failed:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// resolveIncludes binds every IncludeBlock in the package to the
+// DeclarationBlock of the template it refers to, so IncludeBlock.write can
+// emit a direct Go function call instead of a runtime lookup. It must run
+// after every template's DeclarationBlock has been parsed but before any
+// template is written.
+func (p *Package) resolveIncludes() error {
+	manifest := p.Manifest()
+	for _, t := range p.Templates {
+		for _, b := range t.Blocks {
+			inc, ok := b.(*IncludeBlock)
+			if !ok {
+				continue
+			}
+			callee, ok := manifest[inc.Path]
+			if !ok {
+				return fmt.Errorf("ego: %s: include of unknown template %q", t.Path, inc.Path)
+			}
+			if err := inc.bind(callee); err != nil {
+				return fmt.Errorf("ego: %s: %s", t.Path, err)
+			}
+		}
+	}
 	return nil
 }
 
+// Manifest returns the declared signature of every template in the
+// package, keyed by template path, for tooling (doc extractors, linters,
+// the include-block resolver) that needs a template's call shape without
+// re-parsing its source.
+func (p *Package) Manifest() map[string]*DeclarationBlock {
+	m := make(map[string]*DeclarationBlock, len(p.Templates))
+	for _, t := range p.Templates {
+		if decl := t.declarationBlock(); decl != nil {
+			decl.EntryContext = t.startContext()
+			m[t.Path] = decl
+		}
+	}
+	return m
+}
+
 // Writes the package name and consolidated header blocks.
 func (p *Package) writeHeader(w io.Writer) error {
 	if p.Name == "" {
@@ -0,0 +1,143 @@
+package ego
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseDeclaration(t *testing.T) {
+	tests := []struct {
+		content string
+		name    string
+		ctx     string
+		params  []Param
+	}{
+		{
+			content: "func Name(ctx context.Context, user *User)",
+			name:    "Name",
+			ctx:     "ctx",
+			params: []Param{
+				{Name: "ctx", Type: "context.Context"},
+				{Name: "user", Type: "*User"},
+			},
+		},
+		{
+			content: "func Name(ctx context.Context, user *User) error",
+			name:    "Name",
+			ctx:     "ctx",
+			params: []Param{
+				{Name: "ctx", Type: "context.Context"},
+				{Name: "user", Type: "*User"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := parseDeclaration(Pos{}, tt.content)
+		if err != nil {
+			t.Fatalf("parseDeclaration(%q): %s", tt.content, err)
+		}
+		if b.Name != tt.name {
+			t.Errorf("parseDeclaration(%q): Name = %q, want %q", tt.content, b.Name, tt.name)
+		}
+		if b.Ctx != tt.ctx {
+			t.Errorf("parseDeclaration(%q): Ctx = %q, want %q", tt.content, b.Ctx, tt.ctx)
+		}
+		if len(b.Params) != len(tt.params) {
+			t.Fatalf("parseDeclaration(%q): Params = %v, want %v", tt.content, b.Params, tt.params)
+		}
+		for i, p := range tt.params {
+			if b.Params[i] != p {
+				t.Errorf("parseDeclaration(%q): Params[%d] = %v, want %v", tt.content, i, b.Params[i], p)
+			}
+		}
+	}
+}
+
+func TestIncludeContextMismatch(t *testing.T) {
+	callee := &Template{
+		Path: "a.ego",
+		Blocks: []Block{
+			&DeclarationBlock{Content: "func RenderA(c io.Writer)", Name: "RenderA"},
+		},
+	}
+	caller := &Template{
+		Path: "b.ego",
+		Blocks: []Block{
+			&DeclarationBlock{Content: "func RenderB(c io.Writer)", Name: "RenderB"},
+			&ContextBlock{Context: ContextJS},
+			&IncludeBlock{Path: "a.ego"},
+		},
+	}
+	p := &Package{Name: "p", Templates: []*Template{callee, caller}}
+
+	if err := p.resolveIncludes(); err != nil {
+		t.Fatalf("resolveIncludes: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := caller.Write(&buf); err == nil || !strings.Contains(err.Error(), "js context") {
+		t.Errorf("Write of an include under js context calling a text-context callee: err = %v, want a context-mismatch error", err)
+	}
+
+	// Once the callee itself declares it's meant to be called from a js
+	// context, the same include is fine.
+	callee.Blocks = []Block{callee.Blocks[0], &ContextBlock{Context: ContextJS}}
+	if err := p.resolveIncludes(); err != nil {
+		t.Fatalf("resolveIncludes: %s", err)
+	}
+	buf.Reset()
+	if err := caller.Write(&buf); err != nil {
+		t.Errorf("Write of an include whose call-site and callee contexts agree: %s", err)
+	}
+}
+
+// TestStartContextSkipsBlankLeadingText covers the ordinary source shape
+// where a directive doesn't immediately follow the declaration block: the
+// blank TextBlock ego leaves for the newline in between (e.g.
+// "<%! func RenderA(c io.Writer) %>\n<%js %>\n...") must not be mistaken
+// for template content that locks the entry context to ContextText.
+func TestStartContextSkipsBlankLeadingText(t *testing.T) {
+	tpl := &Template{
+		Path: "a.ego",
+		Blocks: []Block{
+			&DeclarationBlock{Content: "func RenderA(c io.Writer)", Name: "RenderA"},
+			&TextBlock{Content: "\n"},
+			&ContextBlock{Context: ContextJS},
+		},
+	}
+	if got := tpl.startContext(); got != ContextJS {
+		t.Errorf("startContext() = %s, want %s", got, ContextJS)
+	}
+}
+
+func TestIncludeBlockBindZeroParamCallee(t *testing.T) {
+	callee := &DeclarationBlock{Name: "Render"}
+
+	if err := (&IncludeBlock{Path: "a.ego"}).bind(callee); err != nil {
+		t.Errorf("bind with no call-site args against a zero-param callee: %s", err)
+	}
+
+	if err := (&IncludeBlock{Path: "a.ego", Args: "1, 2"}).bind(callee); err == nil {
+		t.Error("bind with 2 call-site args against a zero-param callee: want error, got nil")
+	}
+}
+
+func TestIncludeBlockBindCommaInArgument(t *testing.T) {
+	callee := &DeclarationBlock{Name: "Render", Params: []Param{
+		{Name: "c", Type: "io.Writer"},
+		{Name: "u", Type: "*User"},
+	}}
+
+	inc := &IncludeBlock{Path: "a.ego", Args: `User{Name: "a", Age: 1}`}
+	if err := inc.bind(callee); err != nil {
+		t.Errorf("bind with a composite-literal argument containing a comma: %s", err)
+	}
+	if inc.Callee != callee {
+		t.Error("bind did not set Callee")
+	}
+
+	if err := (&IncludeBlock{Path: "a.ego", Args: `User{}, User{}`}).bind(callee); err == nil {
+		t.Error("bind with 2 call-site args against a 1-param callee: want error, got nil")
+	}
+}
@@ -0,0 +1,7 @@
+// Package models holds a sample type used by importer_test.go to verify
+// that moduleImporter can resolve a sibling package in this module.
+package models
+
+type User struct {
+	Name string
+}